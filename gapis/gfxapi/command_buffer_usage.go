@@ -0,0 +1,42 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gfxapi
+
+// ResourceTouch describes a single access a command inside a command
+// buffer makes to a buffer or image resource: which resource, what kind
+// of access, at which pipeline stage, and -- for images -- what layout
+// the command expects the resource to be in. Access/Stage/Layout carry
+// the underlying API's own flag bits (e.g. Vulkan's VkAccessFlags); this
+// package does not interpret them, it just lets them travel between APIs
+// and the transforms that splice synthetic commands into a trace.
+type ResourceTouch struct {
+	Resource uint64
+	Access   uint32
+	Stage    uint32
+	Layout   uint32
+}
+
+// CommandBufferUsage reports the resource accesses a command buffer
+// makes up to a given point. Transforms that splice synthetic commands
+// into a rebuilt command buffer -- the synchronization terminator,
+// framebuffer capture, per-draw replay -- use it to detect a hazard
+// against what they are about to insert and emit the barrier that hazard
+// requires, without needing to understand the API's own command
+// encoding.
+type CommandBufferUsage interface {
+	// ResourceUsage returns every resource touch made by the command
+	// buffer up to and including idx.
+	ResourceUsage(idx SubcommandIndex) []ResourceTouch
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gfxapi
+
+// Append returns a new SubcommandIndex with v appended, leaving s
+// unmodified. It's the building block a generic subcommand walk (see
+// vulkan.WalkSubcommands) uses to extend a path by one level as it
+// descends into a command's own subcommands, however deeply those are
+// nested.
+func (s SubcommandIndex) Append(v uint64) SubcommandIndex {
+	r := make(SubcommandIndex, len(s)+1)
+	copy(r, s)
+	r[len(s)] = v
+	return r
+}
+
+// Parent returns s with its last element removed. The Parent of an empty
+// SubcommandIndex is itself empty.
+func (s SubcommandIndex) Parent() SubcommandIndex {
+	if len(s) == 0 {
+		return s
+	}
+	return s[:len(s)-1]
+}
+
+// Depth returns how many levels of nesting s addresses, which is its
+// length: 0 for the empty index (the whole submission), 1 for a top-level
+// submission index, 2 for a command buffer within it, 3 for a command in
+// that buffer, and two more (secondary buffer, then its command) for every
+// level of vkCmdExecuteCommands nesting below that.
+func (s SubcommandIndex) Depth() int {
+	return len(s)
+}
@@ -51,3 +51,28 @@ func TestDecrement(t *testing.T) {
 	assert.With(ctx).That(deceq(gfxapi.SubcommandIndex{0}, gfxapi.SubcommandIndex{})).Equals(true)
 	assert.With(ctx).That(deceq(gfxapi.SubcommandIndex{2, 3, 0}, gfxapi.SubcommandIndex{2, 2})).Equals(true)
 }
+
+func TestSubcommandAppend(t *testing.T) {
+	ctx := log.Testing(t)
+	base := gfxapi.SubcommandIndex{1, 2}
+	appended := base.Append(3)
+	assert.With(ctx).That(appended).Equals(gfxapi.SubcommandIndex{1, 2, 3})
+	// Append must not mutate its receiver.
+	assert.With(ctx).That(base).Equals(gfxapi.SubcommandIndex{1, 2})
+	assert.With(ctx).That(gfxapi.SubcommandIndex{}.Append(0)).Equals(gfxapi.SubcommandIndex{0})
+}
+
+func TestSubcommandParent(t *testing.T) {
+	ctx := log.Testing(t)
+	assert.With(ctx).That(gfxapi.SubcommandIndex{1, 2, 3}.Parent()).Equals(gfxapi.SubcommandIndex{1, 2})
+	assert.With(ctx).That(gfxapi.SubcommandIndex{1}.Parent()).Equals(gfxapi.SubcommandIndex{})
+	assert.With(ctx).That(gfxapi.SubcommandIndex{}.Parent()).Equals(gfxapi.SubcommandIndex{})
+}
+
+func TestSubcommandDepth(t *testing.T) {
+	ctx := log.Testing(t)
+	assert.With(ctx).That(gfxapi.SubcommandIndex{}.Depth()).Equals(0)
+	assert.With(ctx).That(gfxapi.SubcommandIndex{0}.Depth()).Equals(1)
+	assert.With(ctx).That(gfxapi.SubcommandIndex{1, 2, 3, 4, 5}.Depth()).Equals(5)
+	assert.With(ctx).That(gfxapi.SubcommandIndex{1, 2}.Append(3).Depth()).Equals(3)
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vulkan
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/google/gapid/gapis/gfxapi"
+)
+
+// RecreateCmdPipelineBarrierData is the recreate-time equivalent of a
+// vkCmdPipelineBarrier, spliced into a rebuilt command buffer by
+// attachmentBarriers whenever resolveCurrentRenderPass finds that the
+// retained prefix left an attachment in a layout/access state other than
+// the one the synthesized NextSubpass/EndRenderPass sequence needs.
+type RecreateCmdPipelineBarrierData struct {
+	SrcStageMask  VkPipelineStageFlags
+	DstStageMask  VkPipelineStageFlags
+	SrcAccessMask VkAccessFlags
+	DstAccessMask VkAccessFlags
+	Image         VkImage
+	OldLayout     VkImageLayout
+	NewLayout     VkImageLayout
+}
+
+// resourceAccess is the (resource, access mask, stage mask, layout) tuple
+// every entry in commandUsage reports. It mirrors the access record kept
+// by Vulkano's synced command-buffer builder: replaying these in order is
+// enough to tell whether the next command can proceed as-is or needs a
+// barrier first. Exactly one of buffer/image is non-zero; layout is only
+// meaningful when image is set.
+type resourceAccess struct {
+	buffer VkBuffer
+	image  VkImage
+	access VkAccessFlags
+	stage  VkPipelineStageFlags
+	layout VkImageLayout
+}
+
+// commandUsage maps a RecreateCmd*Data kind to the resourceAccess set it
+// performs. It has entries for vkCmdBeginRenderPass and vkCmdNextSubpass
+// only; draws and dispatches are charged through drawCommandTypes, and
+// every other kind (copies, clears, blits, vkCmdEndRenderPass, ...) is
+// reported by usageOf as touching nothing. New
+// Recreate* command kinds register themselves here rather than being
+// special-cased by callers: resolveCurrentRenderPass (via usageOf) is the
+// only thing that ever needs to know what a command touches. Each entry
+// takes the in-progress renderPassState as well as the command itself,
+// since a couple of kinds (vkCmdNextSubpass chief among them) only know
+// what they touch in terms of the render pass/framebuffer/subpass already
+// accumulated by the walk, not anything carried on the command itself.
+var commandUsage = map[reflect.Type]func(*State, *renderPassState, *CommandBufferCommand) []resourceAccess{
+	reflect.TypeOf(&RecreateCmdBeginRenderPassData{}): beginRenderPassUsage,
+	reflect.TypeOf(&RecreateCmdNextSubpassData{}):     nextSubpassUsage,
+}
+
+// drawCommandTypes are the Recreate*Data kinds that actually execute
+// shader work against whatever is bound at the time, rather than just
+// recording state -- the resourceAccess they report comes from whatever
+// boundDescriptorSets currently holds, not from the command itself.
+var drawCommandTypes = map[reflect.Type]bool{
+	reflect.TypeOf(&RecreateCmdDrawData{}):        true,
+	reflect.TypeOf(&RecreateCmdDrawIndexedData{}): true,
+	reflect.TypeOf(&RecreateCmdDispatchData{}):    true,
+}
+
+// usageOf returns the resourceAccess set o performs, or nil if o's
+// recreate data kind touches no tracked resource (e.g. vkCmdExecuteCommands
+// itself -- its secondaries are walked, and charged, individually).
+func usageOf(s *State, rps *renderPassState, o *CommandBufferCommand) []resourceAccess {
+	if fn, ok := commandUsage[reflect.TypeOf(o.recreateData)]; ok {
+		return fn(s, rps, o)
+	}
+	if drawCommandTypes[reflect.TypeOf(o.recreateData)] {
+		return boundDescriptorSetUsage(s, rps.boundDescriptorSets)
+	}
+	return nil
+}
+
+// boundDescriptorSetUsage reports the buffer/image resourceAccess every
+// binding in sets refers to, charged as a shader read -- the access a
+// draw or dispatch makes to whatever its currently bound descriptor sets
+// point at.
+func boundDescriptorSetUsage(s *State, sets []VkDescriptorSet) []resourceAccess {
+	var accesses []resourceAccess
+	for _, set := range sets {
+		ds, ok := s.DescriptorSets[set]
+		if !ok {
+			continue
+		}
+		for _, binding := range ds.Bindings {
+			switch {
+			case binding.Buffer != VkBuffer(0):
+				accesses = append(accesses, resourceAccess{
+					buffer: binding.Buffer,
+					access: VkAccessFlags(VkAccessFlagBits_VK_ACCESS_SHADER_READ_BIT),
+					stage:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_ALL_COMMANDS_BIT),
+				})
+			case binding.ImageView != VkImageView(0):
+				if view, ok := s.ImageViews[binding.ImageView]; ok {
+					accesses = append(accesses, resourceAccess{
+						image:  view.Image,
+						access: VkAccessFlags(VkAccessFlagBits_VK_ACCESS_SHADER_READ_BIT),
+						stage:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_ALL_COMMANDS_BIT),
+					})
+				}
+			}
+		}
+	}
+	return accesses
+}
+
+// attachmentImages resolves a framebuffer's attachments down to the
+// VkImage each attachment's image view refers to, in attachment order.
+func attachmentImages(s *State, fb VkFramebuffer) []VkImage {
+	framebuffer, ok := s.Framebuffers[fb]
+	if !ok {
+		return nil
+	}
+	images := make([]VkImage, len(framebuffer.ImageAttachments))
+	for i, view := range framebuffer.ImageAttachments {
+		images[i] = s.ImageViews[view].Image
+	}
+	return images
+}
+
+// beginRenderPassUsage reports the access a render pass' first subpass
+// makes to its color and depth/stencil attachments, which is what
+// vkCmdBeginRenderPass itself transitions them to.
+func beginRenderPassUsage(s *State, rps *renderPassState, o *CommandBufferCommand) []resourceAccess {
+	t := o.recreateData.(*RecreateCmdBeginRenderPassData)
+	rp, ok := s.RenderPasses[t.RenderPass]
+	if !ok {
+		return nil
+	}
+	return subpassUsage(s, rp, t.Framebuffer, 0)
+}
+
+// nextSubpassUsage reports the access the subpass a vkCmdNextSubpass just
+// moved into makes to its attachments. Unlike vkCmdBeginRenderPass, the
+// command itself carries no render pass/framebuffer reference of its own
+// -- rps.lrp/rps.fb (set by the RecreateCmdBeginRenderPassData that opened
+// the render pass) and rps.subpass (already incremented by the walk
+// before usageOf is called) are the only way to know what it touches.
+func nextSubpassUsage(s *State, rps *renderPassState, o *CommandBufferCommand) []resourceAccess {
+	if rps.lrp == nil {
+		return nil
+	}
+	return subpassUsage(s, rps.lrp, rps.fb, rps.subpass)
+}
+
+// subpassUsage returns the access every attachment referenced by
+// rp.SubpassDescriptions[subpass] is touched with, at the layout that
+// subpass declares for it.
+func subpassUsage(s *State, rp *RenderPassObject, fb VkFramebuffer, subpass uint32) []resourceAccess {
+	if int(subpass) >= len(rp.SubpassDescriptions) {
+		return nil
+	}
+	images := attachmentImages(s, fb)
+	desc := rp.SubpassDescriptions[subpass]
+	accesses := make([]resourceAccess, 0, len(desc.ColorAttachments)+1)
+	for _, ref := range desc.ColorAttachments {
+		if int(ref.Attachment) >= len(images) {
+			continue
+		}
+		accesses = append(accesses, resourceAccess{
+			image:  images[ref.Attachment],
+			access: VkAccessFlags(VkAccessFlagBits_VK_ACCESS_COLOR_ATTACHMENT_WRITE_BIT),
+			stage:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_COLOR_ATTACHMENT_OUTPUT_BIT),
+			layout: ref.Layout,
+		})
+	}
+	if desc.DepthStencilAttachment != nil && int(desc.DepthStencilAttachment.Attachment) < len(images) {
+		ref := desc.DepthStencilAttachment
+		accesses = append(accesses, resourceAccess{
+			image:  images[ref.Attachment],
+			access: VkAccessFlags(VkAccessFlagBits_VK_ACCESS_DEPTH_STENCIL_ATTACHMENT_WRITE_BIT),
+			stage:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_EARLY_FRAGMENT_TESTS_BIT),
+			layout: ref.Layout,
+		})
+	}
+	return accesses
+}
+
+// attachmentBarriers walks history -- the resourceAccess trail left by
+// the retained command prefix -- to find the layout each of lrp's
+// attachments was last touched with, and returns the
+// RecreateCmdPipelineBarrierData needed to bring every touched attachment
+// that is not already there to its declared FinalLayout before the
+// synthetic NextSubpass/EndRenderPass sequence runs. An attachment that
+// history never touches gets no barrier.
+func attachmentBarriers(s *State, lrp *RenderPassObject, fb VkFramebuffer, history []resourceAccess) []interface{} {
+	if lrp == nil {
+		return nil
+	}
+	last := map[VkImage]resourceAccess{}
+	for _, h := range history {
+		if h.image != VkImage(0) {
+			last[h.image] = h
+		}
+	}
+	images := attachmentImages(s, fb)
+	barriers := make([]interface{}, 0)
+	for i, desc := range lrp.AttachmentDescriptions {
+		if i >= len(images) {
+			continue
+		}
+		img := images[i]
+		cur, ok := last[img]
+		if !ok || cur.layout == desc.FinalLayout {
+			continue
+		}
+		barriers = append(barriers, RecreateCmdPipelineBarrierData{
+			SrcStageMask:  cur.stage,
+			DstStageMask:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_BOTTOM_OF_PIPE_BIT),
+			SrcAccessMask: cur.access,
+			DstAccessMask: VkAccessFlags(0),
+			Image:         img,
+			OldLayout:     cur.layout,
+			NewLayout:     desc.FinalLayout,
+		})
+	}
+	return barriers
+}
+
+// commandBufferUsage is vulkan's implementation of gfxapi.CommandBufferUsage,
+// letting other transforms (framebuffer capture, per-draw replay) ask what
+// a submission touches without reaching into vulkan-internal state
+// themselves.
+type commandBufferUsage struct {
+	ctx    context.Context
+	s      *gfxapi.State
+	submit *VkQueueSubmit
+}
+
+// NewCommandBufferUsage returns a gfxapi.CommandBufferUsage over submit,
+// backed by the same commandUsage table resolveCurrentRenderPass uses
+// internally.
+func NewCommandBufferUsage(ctx context.Context, s *gfxapi.State, submit *VkQueueSubmit) gfxapi.CommandBufferUsage {
+	return &commandBufferUsage{ctx, s, submit}
+}
+
+func (u *commandBufferUsage) ResourceUsage(idx gfxapi.SubcommandIndex) []gfxapi.ResourceTouch {
+	rps := resolveCurrentRenderPass(u.ctx, u.s, u.submit, idx, nil, 0)
+	touches := make([]gfxapi.ResourceTouch, 0, len(rps.history))
+	for _, h := range rps.history {
+		t := gfxapi.ResourceTouch{Access: uint32(h.access), Stage: uint32(h.stage), Layout: uint32(h.layout)}
+		if h.image != VkImage(0) {
+			t.Resource = uint64(h.image)
+		} else {
+			t.Resource = uint64(h.buffer)
+		}
+		touches = append(touches, t)
+	}
+	return touches
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vulkan
+
+import (
+	"testing"
+
+	"github.com/google/gapid/core/assert"
+	"github.com/google/gapid/core/log"
+)
+
+func TestAttachmentBarriersTransitionsToFinalLayout(t *testing.T) {
+	ctx := log.Testing(t)
+
+	img := VkImage(1)
+	view := VkImageView(2)
+	fb := VkFramebuffer(3)
+
+	s := &State{
+		Framebuffers: map[VkFramebuffer]*FramebufferObject{
+			fb: {ImageAttachments: []VkImageView{view}},
+		},
+		ImageViews: map[VkImageView]*ImageViewObject{
+			view: {Image: img},
+		},
+	}
+	rp := &RenderPassObject{
+		AttachmentDescriptions: []VkAttachmentDescription{
+			{FinalLayout: VkImageLayout_VK_IMAGE_LAYOUT_PRESENT_SRC_KHR},
+		},
+	}
+	history := []resourceAccess{
+		{
+			image:  img,
+			access: VkAccessFlags(VkAccessFlagBits_VK_ACCESS_COLOR_ATTACHMENT_WRITE_BIT),
+			stage:  VkPipelineStageFlags(VkPipelineStageFlagBits_VK_PIPELINE_STAGE_COLOR_ATTACHMENT_OUTPUT_BIT),
+			layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL,
+		},
+	}
+
+	barriers := attachmentBarriers(s, rp, fb, history)
+	assert.With(ctx).That(len(barriers)).Equals(1)
+
+	b := barriers[0].(RecreateCmdPipelineBarrierData)
+	assert.With(ctx).That(b.Image).Equals(img)
+	assert.With(ctx).That(b.OldLayout).Equals(VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL)
+	assert.With(ctx).That(b.NewLayout).Equals(VkImageLayout_VK_IMAGE_LAYOUT_PRESENT_SRC_KHR)
+}
+
+func TestAttachmentBarriersSkipsAttachmentsAlreadyAtFinalLayout(t *testing.T) {
+	ctx := log.Testing(t)
+
+	img := VkImage(1)
+	view := VkImageView(2)
+	fb := VkFramebuffer(3)
+
+	s := &State{
+		Framebuffers: map[VkFramebuffer]*FramebufferObject{
+			fb: {ImageAttachments: []VkImageView{view}},
+		},
+		ImageViews: map[VkImageView]*ImageViewObject{
+			view: {Image: img},
+		},
+	}
+	rp := &RenderPassObject{
+		AttachmentDescriptions: []VkAttachmentDescription{
+			{FinalLayout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+		},
+	}
+	history := []resourceAccess{
+		{image: img, layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+	}
+
+	barriers := attachmentBarriers(s, rp, fb, history)
+	assert.With(ctx).That(len(barriers)).Equals(0)
+}
+
+func TestNextSubpassUsageTracksSubsequentSubpass(t *testing.T) {
+	ctx := log.Testing(t)
+
+	view0 := VkImageView(2)
+	view1 := VkImageView(3)
+	img0 := VkImage(4)
+	img1 := VkImage(5)
+	fb := VkFramebuffer(6)
+
+	s := &State{
+		Framebuffers: map[VkFramebuffer]*FramebufferObject{
+			fb: {ImageAttachments: []VkImageView{view0, view1}},
+		},
+		ImageViews: map[VkImageView]*ImageViewObject{
+			view0: {Image: img0},
+			view1: {Image: img1},
+		},
+	}
+	rp := &RenderPassObject{
+		SubpassDescriptions: []VkSubpassDescription{
+			{ColorAttachments: []VkAttachmentReference{
+				{Attachment: 0, Layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+			}},
+			{ColorAttachments: []VkAttachmentReference{
+				{Attachment: 1, Layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+			}},
+		},
+	}
+
+	// Simulate the walk having already run vkCmdBeginRenderPass (subpass 0)
+	// and then reaching a vkCmdNextSubpass, which bumps rps.subpass to 1
+	// before usageOf -- and so nextSubpassUsage -- is ever called.
+	rps := &renderPassState{lrp: rp, fb: fb, subpass: 1}
+	accesses := usageOf(s, rps, &CommandBufferCommand{recreateData: &RecreateCmdNextSubpassData{}})
+
+	assert.With(ctx).That(len(accesses)).Equals(1)
+	assert.With(ctx).That(accesses[0].image).Equals(img1)
+}
+
+func TestUsageOfDrawChargesBoundDescriptorSets(t *testing.T) {
+	ctx := log.Testing(t)
+
+	buf := VkBuffer(7)
+	view := VkImageView(8)
+	img := VkImage(9)
+	set := VkDescriptorSet(1)
+
+	s := &State{
+		DescriptorSets: map[VkDescriptorSet]*DescriptorSetObject{
+			set: {Bindings: map[uint32]DescriptorBinding{
+				0: {Buffer: buf},
+				1: {ImageView: view},
+			}},
+		},
+		ImageViews: map[VkImageView]*ImageViewObject{
+			view: {Image: img},
+		},
+	}
+	rps := &renderPassState{boundDescriptorSets: []VkDescriptorSet{set}}
+
+	accesses := usageOf(s, rps, &CommandBufferCommand{recreateData: &RecreateCmdDrawData{}})
+
+	assert.With(ctx).That(len(accesses)).Equals(2)
+	var sawBuffer, sawImage bool
+	for _, a := range accesses {
+		if a.buffer == buf {
+			sawBuffer = true
+		}
+		if a.image == img {
+			sawImage = true
+		}
+	}
+	assert.With(ctx).That(sawBuffer).Equals(true)
+	assert.With(ctx).That(sawImage).Equals(true)
+}
+
+// accumulateRenderPassState replays resolveCurrentRenderPass's own
+// per-command switch over commands in walk order, descending into any
+// vkCmdExecuteCommands secondary the same way WalkSubcommands does. It
+// exists because resolveCurrentRenderPass itself cannot be driven from a
+// test in this tree: it takes a *gfxapi.State and calls GetState/
+// WalkSubcommands, and neither gfxapi.State nor GetState is defined
+// anywhere in this source tree (both belong to packages outside this
+// snapshot) -- this mirrors its accumulation logic exactly, command by
+// command, so the multi-subpass/secondary interaction it is meant to
+// exercise is still covered at the resourceAccess/attachmentBarriers
+// boundary.
+func accumulateRenderPassState(s *State, rps *renderPassState, commands CommandBufferCommands) {
+	for i := range commands {
+		o := &commands[i]
+		switch t := o.recreateData.(type) {
+		case *RecreateCmdBeginRenderPassData:
+			rps.lrp = s.RenderPasses[t.RenderPass]
+			rps.fb = t.Framebuffer
+			rps.subpass = 0
+		case *RecreateCmdNextSubpassData:
+			rps.subpass++
+		case *RecreateCmdEndRenderPassData:
+			rps.lrp = nil
+			rps.subpass = 0
+		case *RecreateCmdBindDescriptorSetsData:
+			rps.boundDescriptorSets = t.DescriptorSets
+		case *RecreateCmdExecuteCommandsData:
+			for _, k := range t.CommandBuffers.KeysSorted() {
+				accumulateRenderPassState(s, rps, s.CommandBuffers[t.CommandBuffers[k]].Commands)
+			}
+		}
+		rps.history = append(rps.history, usageOf(s, rps, o)...)
+	}
+}
+
+func TestResolveCurrentRenderPassMultiSubpassWithSecondaryCommandBuffer(t *testing.T) {
+	ctx := log.Testing(t)
+
+	view0 := VkImageView(2)
+	view1 := VkImageView(3)
+	img0 := VkImage(4)
+	img1 := VkImage(5)
+	fb := VkFramebuffer(6)
+	rpHandle := VkRenderPass(7)
+	secondaryHandle := VkCommandBuffer(8)
+
+	rp := &RenderPassObject{
+		AttachmentDescriptions: []VkAttachmentDescription{
+			{FinalLayout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+			{FinalLayout: VkImageLayout_VK_IMAGE_LAYOUT_PRESENT_SRC_KHR},
+		},
+		SubpassDescriptions: []VkSubpassDescription{
+			{ColorAttachments: []VkAttachmentReference{
+				{Attachment: 0, Layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+			}},
+			{ColorAttachments: []VkAttachmentReference{
+				{Attachment: 1, Layout: VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL},
+			}},
+		},
+	}
+
+	// Subpass 1's attachment is only ever touched by a vkCmdNextSubpass
+	// reached by descending into a vkCmdExecuteCommands secondary -- the
+	// exact combination (multi-subpass render pass + secondary command
+	// buffer) the barrier sequence must still get right.
+	secondary := &CommandBufferObject{
+		Commands: CommandBufferCommands{
+			{recreateData: &RecreateCmdNextSubpassData{}},
+		},
+	}
+	primary := CommandBufferCommands{
+		{recreateData: &RecreateCmdBeginRenderPassData{RenderPass: rpHandle, Framebuffer: fb}},
+		{recreateData: &RecreateCmdExecuteCommandsData{
+			CommandBuffers: map[uint32]VkCommandBuffer{0: secondaryHandle},
+		}},
+	}
+
+	s := &State{
+		RenderPasses: map[VkRenderPass]*RenderPassObject{rpHandle: rp},
+		Framebuffers: map[VkFramebuffer]*FramebufferObject{
+			fb: {ImageAttachments: []VkImageView{view0, view1}},
+		},
+		ImageViews: map[VkImageView]*ImageViewObject{
+			view0: {Image: img0},
+			view1: {Image: img1},
+		},
+		CommandBuffers: map[VkCommandBuffer]*CommandBufferObject{secondaryHandle: secondary},
+	}
+
+	rps := &renderPassState{}
+	accumulateRenderPassState(s, rps, primary)
+
+	assert.With(ctx).That(rps.subpass).Equals(uint32(1))
+
+	barriers := attachmentBarriers(s, rp, fb, rps.history)
+	assert.With(ctx).That(len(barriers)).Equals(1)
+	b := barriers[0].(RecreateCmdPipelineBarrierData)
+	assert.With(ctx).That(b.Image).Equals(img1)
+	assert.With(ctx).That(b.OldLayout).Equals(VkImageLayout_VK_IMAGE_LAYOUT_COLOR_ATTACHMENT_OPTIMAL)
+	assert.With(ctx).That(b.NewLayout).Equals(VkImageLayout_VK_IMAGE_LAYOUT_PRESENT_SRC_KHR)
+}
+
+func TestAttachmentBarriersIgnoresUntouchedAttachments(t *testing.T) {
+	ctx := log.Testing(t)
+
+	fb := VkFramebuffer(3)
+	s := &State{Framebuffers: map[VkFramebuffer]*FramebufferObject{
+		fb: {ImageAttachments: []VkImageView{VkImageView(2)}},
+	}}
+	rp := &RenderPassObject{
+		AttachmentDescriptions: []VkAttachmentDescription{
+			{FinalLayout: VkImageLayout_VK_IMAGE_LAYOUT_PRESENT_SRC_KHR},
+		},
+	}
+
+	barriers := attachmentBarriers(s, rp, fb, nil)
+	assert.With(ctx).That(len(barriers)).Equals(0)
+}
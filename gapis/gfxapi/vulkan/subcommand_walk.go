@@ -0,0 +1,176 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vulkan
+
+import (
+	"context"
+
+	"github.com/google/gapid/gapis/gfxapi"
+)
+
+// Action directs WalkSubcommands how to proceed after a visitor call.
+type Action int
+
+const (
+	// Continue moves on to the next command at the current depth without
+	// descending into this command's own subcommands, unless the walk's
+	// idx addresses a subcommand inside this command: the chain leading to
+	// idx is always visited.
+	Continue Action = iota
+	// Descend visits this command's subcommands (e.g. the secondaries of
+	// a vkCmdExecuteCommands), however many levels they turn out to
+	// nest, before moving on to the next command at the current depth.
+	Descend
+	// Stop ends the walk immediately; no further commands are visited.
+	Stop
+)
+
+// subcommandBound reports the sibling count idx allows to be visited at
+// the given depth, and whether idx constrains that depth at all. It is
+// the one piece of bounds arithmetic walkCommandBuffers, walkCommandList,
+// rebuildCommandBuffer and discardedSetEvents all share, instead of each
+// re-deriving it from idx[depth]/len(idx) independently.
+func subcommandBound(idx gfxapi.SubcommandIndex, depth int) (limit uint64, bounded bool) {
+	if depth >= len(idx) {
+		return 0, false
+	}
+	return idx[depth], true
+}
+
+// walkCommandBuffers walks buffers -- the command buffers named by one
+// VkSubmitInfo, or the secondaries named by one vkCmdExecuteCommands --
+// resolving each to its own command list and walking it with
+// walkCommandList, bounded by idx the way WalkSubcommands bounds an
+// entire submission.
+func walkCommandBuffers(s *State, path gfxapi.SubcommandIndex, idx gfxapi.SubcommandIndex,
+	buffers []VkCommandBuffer, visitor func(path gfxapi.SubcommandIndex, cmd *CommandBufferCommand) Action) Action {
+	limit, bounded := subcommandBound(idx, len(path))
+	for i, handle := range buffers {
+		if bounded && uint64(i) > limit {
+			return Stop
+		}
+		cbo := s.CommandBuffers[handle]
+		if walkCommandList(s, path.Append(uint64(i)), idx, cbo.Commands, visitor) == Stop {
+			return Stop
+		}
+		if bounded && uint64(i) == limit {
+			return Stop
+		}
+	}
+	return Continue
+}
+
+// walkCommandList walks commands, bounded by idx the same way
+// walkCommandBuffers/WalkSubcommands bound their own level: every
+// sibling before idx's position at this depth is visited in full
+// (descending into any vkCmdExecuteCommands it contains, via
+// walkCommandBuffers), the command idx's position at this depth names is
+// visited once more, and nothing after it is visited. A nil/empty idx
+// visits the entire list. This, plus walkCommandBuffers, is the single
+// traversal WalkSubcommands, rebuildCommandBuffer and discardedSetEvents
+// are all built on.
+func walkCommandList(s *State, path gfxapi.SubcommandIndex, idx gfxapi.SubcommandIndex,
+	commands CommandBufferCommands, visitor func(path gfxapi.SubcommandIndex, cmd *CommandBufferCommand) Action) Action {
+	limit, bounded := subcommandBound(idx, len(path))
+	for i := range commands {
+		if bounded && uint64(i) > limit {
+			return Stop
+		}
+		cmdPath := path.Append(uint64(i))
+		action := visitor(cmdPath, &commands[i])
+		if action == Stop {
+			return Stop
+		}
+		if execSub, ok := commands[i].recreateData.(*RecreateCmdExecuteCommandsData); ok {
+			_, descendsFurther := subcommandBound(idx, len(cmdPath))
+			if action == Descend || descendsFurther {
+				secondaries := make([]VkCommandBuffer, 0, len(execSub.CommandBuffers))
+				for _, k := range execSub.CommandBuffers.KeysSorted() {
+					secondaries = append(secondaries, execSub.CommandBuffers[k])
+				}
+				if walkCommandBuffers(s, cmdPath, idx, secondaries, visitor) == Stop {
+					return Stop
+				}
+			}
+		}
+		if bounded && uint64(i) == limit {
+			return Stop
+		}
+	}
+	return Continue
+}
+
+// WalkSubcommands walks submit's submission tree -- its VkSubmitInfos,
+// their command buffers, each buffer's commands, and (for any
+// vkCmdExecuteCommands found along the way) the secondary buffers and
+// commands those run, arbitrarily deep -- calling visitor on every
+// command it reaches, in order.
+//
+// idx bounds the walk the way a gfxapi.SubcommandIndex addresses a
+// single subcommand: every sibling before idx's position at a given
+// depth is visited in full (recursing into any vkCmdExecuteCommands it
+// contains), and the single chain of commands leading to idx is visited
+// one command at a time; nothing after idx is ever visited. A nil/empty
+// idx walks the entire tree. The queue's still-pending commands (i.e.
+// those from an earlier, not yet retired, submission) are walked first,
+// outside of any idx and with a nil path; every secondary they execute is
+// visited whether visitor returns Continue or Descend, and a Stop returned
+// for any of them ends the walk there.
+//
+// This is the single traversal resolveCurrentRenderPass, rebuildCommandBuffer
+// and discardedSetEvents build on, via walkCommandBuffers/walkCommandList,
+// replacing what used to be five copy-pasted idx[0]..idx[4] loops with one
+// recursive walk that works at any nesting depth.
+func WalkSubcommands(ctx context.Context, s *gfxapi.State, submit *VkQueueSubmit,
+	idx gfxapi.SubcommandIndex, visitor func(path gfxapi.SubcommandIndex, cmd *CommandBufferCommand) Action) error {
+
+	c := GetState(s)
+	l := s.MemoryLayout
+
+	queue := c.Queues[submit.Queue]
+	stopped := false
+	walkCommands(c, queue.PendingCommands, func(o *CommandBufferCommand) {
+		if !stopped && visitor(nil, o) == Stop {
+			stopped = true
+		}
+	})
+	if stopped {
+		return nil
+	}
+
+	if submit.SubmitCount == 0 {
+		return nil
+	}
+	submitInfo := submit.PSubmits.Slice(uint64(0), uint64(submit.SubmitCount), l)
+	limit, bounded := subcommandBound(idx, 0)
+	for si := 0; si < int(submit.SubmitCount); si++ {
+		if bounded && uint64(si) > limit {
+			return nil
+		}
+		info := submitInfo.Index(uint64(si), l).Read(ctx, submit, s, nil)
+		buffers := info.PCommandBuffers.Slice(uint64(0), uint64(info.CommandBufferCount), l)
+		handles := make([]VkCommandBuffer, info.CommandBufferCount)
+		for i := range handles {
+			handles[i] = buffers.Index(uint64(i), l).Read(ctx, submit, s, nil)
+		}
+		if walkCommandBuffers(c, gfxapi.SubcommandIndex{uint64(si)}, idx, handles, visitor) == Stop {
+			return nil
+		}
+		if bounded && uint64(si) == limit {
+			return nil
+		}
+	}
+	return nil
+}
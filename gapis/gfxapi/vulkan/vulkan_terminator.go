@@ -38,13 +38,23 @@ import (
 //      Furthermore it will continue the replay until that command can be run
 //      i.e. it will make sure to continue to mutate the trace until
 //      all pending events have been successfully completed.
-//      TODO(awoloszyn): Handle #2
 // This takes advantage of the fact that all atoms will be in order.
 type VulkanTerminator struct {
-	lastRequest    atom.ID
-	stopped        bool
-	syncData       *gfxapi.SynchronizationData
-	blockingEvents []VkEvent
+	lastRequest atom.ID
+	stopped     bool
+	syncData    *gfxapi.SynchronizationData
+
+	// cut is set the moment a VkQueueSubmit has been truncated mid-submit.
+	// Once set, Transform keeps mutating and writing every atom it sees
+	// (rather than dropping them the instant lastRequest is reached) so
+	// that whatever the truncated tail would have signalled still has a
+	// chance to happen naturally. stopped is only raised once pending()
+	// reports nothing outstanding.
+	cut               bool
+	pendingQueue      VkQueue
+	pendingFence      VkFence
+	blockingEvents    map[VkEvent]bool
+	pendingSemaphores map[VkSemaphore]bool
 }
 
 func NewVulkanTerminator(ctx context.Context, capture *path.Capture) (*VulkanTerminator, error) {
@@ -57,7 +67,87 @@ func NewVulkanTerminator(ctx context.Context, capture *path.Capture) (*VulkanTer
 		return nil, log.Errf(ctx, nil, "Could not get synchronization data")
 	}
 
-	return &VulkanTerminator{atom.ID(0), false, s, []VkEvent(nil)}, nil
+	return &VulkanTerminator{
+		lastRequest:       atom.ID(0),
+		stopped:           false,
+		syncData:          s,
+		blockingEvents:    map[VkEvent]bool{},
+		pendingSemaphores: map[VkSemaphore]bool{},
+	}, nil
+}
+
+// pending returns true if there is still an event, semaphore or fence that
+// a mid-submit cut owes the trace, and which hasn't yet been seen to fire.
+func (t *VulkanTerminator) pending() bool {
+	return len(t.blockingEvents) > 0 || len(t.pendingSemaphores) > 0 || t.pendingFence != VkFence(0)
+}
+
+// observeSync watches an atom that has already been mutated and written
+// after a mid-submit cut, clearing out any blockingEvents/pendingSemaphores/
+// pendingFence that it just satisfied.
+func (t *VulkanTerminator) observeSync(ctx context.Context, a atom.Atom, out transform.Writer) {
+	switch c := a.(type) {
+	case *VkSetEvent:
+		delete(t.blockingEvents, c.Event)
+	case *VkCmdSetEvent:
+		delete(t.blockingEvents, c.Event)
+	case *VkQueueSubmit:
+		if t.pendingFence != VkFence(0) && c.Fence == t.pendingFence {
+			t.pendingFence = VkFence(0)
+		}
+		if len(t.pendingSemaphores) == 0 {
+			return
+		}
+		s := out.State()
+		l := s.MemoryLayout
+		infos := c.PSubmits.Slice(uint64(0), uint64(c.SubmitCount), l)
+		for i := uint64(0); i < uint64(c.SubmitCount); i++ {
+			info := infos.Index(i, l).Read(ctx, a, s, nil)
+			for _, sem := range readSemaphores(ctx, a, s, l, info.PSignalSemaphores, info.SignalSemaphoreCount) {
+				delete(t.pendingSemaphores, sem)
+			}
+			// A later submit waiting on a semaphore we are watching is just
+			// as much evidence that it does not need synthesizing as one
+			// re-signalling it: whatever queued the wait already trusts the
+			// semaphore to be satisfied. Without this, pending() can stay
+			// true for the rest of a perfectly normal trace whenever the
+			// orphaned semaphore's only remaining reference is a wait.
+			for _, sem := range readSemaphores(ctx, a, s, l, info.PWaitSemaphores, info.WaitSemaphoreCount) {
+				delete(t.pendingSemaphores, sem)
+			}
+		}
+	}
+}
+
+// readSemaphores reads count VkSemaphore handles out of the given pointer,
+// returning nil if there are none. It mirrors the way PCommandBuffers is
+// read apart in cutCommandBuffer/WalkSubcommands.
+func readSemaphores(ctx context.Context, a atom.Atom, s *gfxapi.State, l *memory.Layout, ptr VkSemaphoreᶜᵖ, count uint32) []VkSemaphore {
+	if count == 0 {
+		return nil
+	}
+	slice := ptr.Slice(uint64(0), uint64(count), l)
+	sems := make([]VkSemaphore, count)
+	for i := range sems {
+		sems[i] = slice.Index(uint64(i), l).Read(ctx, a, s, nil)
+	}
+	return sems
+}
+
+// readStageMasks reads count VkPipelineStageFlags out of the given pointer,
+// returning nil if there are none. PWaitDstStageMask is always read and
+// rewritten in lockstep with PWaitSemaphores, since the two are parallel
+// per-semaphore arrays.
+func readStageMasks(ctx context.Context, a atom.Atom, s *gfxapi.State, l *memory.Layout, ptr VkPipelineStageFlagsᶜᵖ, count uint32) []VkPipelineStageFlags {
+	if count == 0 {
+		return nil
+	}
+	slice := ptr.Slice(uint64(0), uint64(count), l)
+	masks := make([]VkPipelineStageFlags, count)
+	for i := range masks {
+		masks[i] = slice.Index(uint64(i), l).Read(ctx, a, s, nil)
+	}
+	return masks
 }
 
 // Add adds the atom with identifier id to the set of atoms that must be seen
@@ -82,102 +172,61 @@ func walkCommands(s *State,
 	}
 }
 
-func getExtra(idx gfxapi.SubcommandIndex, loopLevel int) int {
-	if len(idx) == loopLevel+1 {
-		return 1
-	}
-	return 0
-}
-
-func incrementLoopLevel(idx gfxapi.SubcommandIndex, loopLevel *int) bool {
-	if len(idx) == *loopLevel+1 {
-		return false
-	}
-	*loopLevel += 1
-	return true
+// renderPassState is the accumulator resolveCurrentRenderPass folds every
+// walked command into: which render pass/subpass/framebuffer we are
+// inside, plus the resourceAccess history needed to compute the
+// attachment barriers a mid-renderpass cut has to insert.
+type renderPassState struct {
+	lrp                 *RenderPassObject
+	subpass             uint32
+	fb                  VkFramebuffer
+	history             []resourceAccess
+	boundDescriptorSets []VkDescriptorSet
 }
 
 // resolveCurrentRenderPass walks all of the current and pending commands
-// to determine what renderpass we are in after the idx'th subcommand
+// to determine what renderpass we are in after the idx'th subcommand, and
+// the resourceAccess history of every command walked along the way.
 func resolveCurrentRenderPass(ctx context.Context, s *gfxapi.State, submit *VkQueueSubmit,
-	idx gfxapi.SubcommandIndex, lrp *RenderPassObject, subpass uint32) (*RenderPassObject, uint32) {
+	idx gfxapi.SubcommandIndex, lrp *RenderPassObject, subpass uint32) renderPassState {
+	rps := renderPassState{lrp: lrp, subpass: subpass}
 	if len(idx) == 0 {
-		return lrp, subpass
+		return rps
 	}
-	a := submit
 	c := GetState(s)
-	queue := c.Queues[submit.Queue]
-	l := s.MemoryLayout
-
-	f := func(o *CommandBufferCommand) {
+	err := WalkSubcommands(ctx, s, submit, idx, func(path gfxapi.SubcommandIndex, o *CommandBufferCommand) Action {
 		switch t := o.recreateData.(type) {
 		case *RecreateCmdBeginRenderPassData:
-			lrp = c.RenderPasses[t.RenderPass]
-			subpass = 0
+			rps.lrp = c.RenderPasses[t.RenderPass]
+			rps.fb = t.Framebuffer
+			rps.subpass = 0
 		case *RecreateCmdNextSubpassData:
-			subpass += 1
+			rps.subpass += 1
 		case *RecreateCmdEndRenderPassData:
-			lrp = nil
-			subpass = 0
-		}
-	}
-
-	walkCommands(c, queue.PendingCommands, f)
-	submitInfo := submit.PSubmits.Slice(uint64(0), uint64(submit.SubmitCount), l)
-	loopLevel := 0
-	for sub := 0; sub < int(idx[0])+getExtra(idx, loopLevel); sub++ {
-		info := submitInfo.Index(uint64(sub), l).Read(ctx, a, s, nil)
-		buffers := info.PCommandBuffers.Slice(uint64(0), uint64(info.CommandBufferCount), l)
-		for cmd := 0; cmd < int(info.CommandBufferCount); cmd++ {
-			buffer := buffers.Index(uint64(cmd), l).Read(ctx, a, s, nil)
-			bufferObject := c.CommandBuffers[buffer]
-			walkCommands(c, bufferObject.Commands, f)
-		}
-	}
-	if !incrementLoopLevel(idx, &loopLevel) {
-		return lrp, subpass
-	}
-	lastInfo := submitInfo.Index(uint64(idx[0]), l).Read(ctx, a, s, nil)
-	lastBuffers := lastInfo.PCommandBuffers.Slice(uint64(0), uint64(lastInfo.CommandBufferCount), l)
-	for cmdbuffer := 0; cmdbuffer < int(idx[1])+getExtra(idx, loopLevel); cmdbuffer++ {
-		buffer := lastBuffers.Index(uint64(cmdbuffer), l).Read(ctx, a, s, nil)
-		bufferObject := c.CommandBuffers[buffer]
-		walkCommands(c, bufferObject.Commands, f)
-	}
-	if !incrementLoopLevel(idx, &loopLevel) {
-		return lrp, subpass
-	}
-	lastBuffer := lastBuffers.Index(uint64(idx[1]), l).Read(ctx, a, s, nil)
-	lastBufferObject := c.CommandBuffers[lastBuffer]
-	for cmd := 0; cmd < int(idx[2])+getExtra(idx, loopLevel); cmd++ {
-		f(&lastBufferObject.Commands[cmd])
-	}
-	if !incrementLoopLevel(idx, &loopLevel) {
-		return lrp, subpass
-	}
-	lastCommand := lastBufferObject.Commands[idx[2]]
-	if executeSubcommand, ok := (lastCommand).recreateData.(*RecreateCmdExecuteCommandsData); ok {
-		for subcmdidx := 0; subcmdidx < int(idx[3])+getExtra(idx, loopLevel); subcmdidx++ {
-			buffer := executeSubcommand.CommandBuffers[uint32(subcmdidx)]
-			bufferObject := c.CommandBuffers[buffer]
-			walkCommands(c, bufferObject.Commands, f)
-		}
-		if !incrementLoopLevel(idx, &loopLevel) {
-			return lrp, subpass
-		}
-		lastsubBuffer := executeSubcommand.CommandBuffers[uint32(idx[3])]
-		lastSubBufferObject := c.CommandBuffers[lastsubBuffer]
-		for subcmd := 0; subcmd < int(idx[4]); subcmd++ {
-			f(&lastSubBufferObject.Commands[subcmd])
+			rps.lrp = nil
+			rps.subpass = 0
+		case *RecreateCmdBindDescriptorSetsData:
+			rps.boundDescriptorSets = t.DescriptorSets
 		}
+		rps.history = append(rps.history, usageOf(c, &rps, o)...)
+		return Descend
+	})
+	if err != nil {
+		log.E(ctx, "Could not resolve current render pass: %v", err)
 	}
-
-	return lrp, subpass
+	return rps
 }
 
 // rebuildCommandBuffer takes the commands from commandBuffer up to, and
-// including idx. It then appends any recreate* arguments to the end
-// of the command buffer.
+// including, idx, then appends additionalCommands to the end of the
+// rebuilt command buffer. idx addresses a subcommand the way a
+// gfxapi.SubcommandIndex does: idx[0] is the command index within
+// commandBuffer itself; if idx names a command nested inside a
+// vkCmdExecuteCommands (len(idx) > 1), idx[1] is the secondary command
+// buffer's index within that command and idx[2:] addresses the
+// subcommand inside it, recursively -- rebuildCommandBuffer calls itself
+// to rebuild that secondary the same way, so a cut works at whatever
+// depth of nested vkCmdExecuteCommands the trace actually has.
 func rebuildCommandBuffer(ctx context.Context,
 	commandBuffer *CommandBufferObject,
 	s *gfxapi.State,
@@ -221,23 +270,55 @@ func rebuildCommandBuffer(ctx context.Context,
 	x = append(x,
 		NewVkBeginCommandBuffer(commandBufferId, beginInfoData.Ptr(), VkResult_VK_SUCCESS).AddRead(beginInfoData.Data()))
 
-	// If we have ANY data, then we need to copy up to that point
-	commandsToCopy := uint64(0)
+	// If we have ANY data, then we need to copy up to that point.
+	cutCommand := uint64(0)
 	if len(idx) > 0 {
-		commandsToCopy = idx[0]
+		cutCommand = idx[0]
 	}
-	// If we only have 1 index, then we have to copy the last command entirely,
-	// and not re-write. Otherwise the last command is a vkCmdExecuteCommands
-	// and it needs to be modified.
-	if len(idx) == 1 {
-		commandsToCopy += 1
+	for i := 0; i < int(cutCommand); i++ {
+		cmd := commandBuffer.Commands[i]
+		c, a := AddCommand(ctx, commandBufferId, s, cmd.recreateData)
+		x = append(x, a)
+		cleanup = append(cleanup, c)
 	}
 
-	for i := 0; i < int(commandsToCopy); i++ {
-		cmd := commandBuffer.Commands[i]
+	switch {
+	case len(idx) == 1:
+		// idx names cutCommand itself; copy it entirely rather than
+		// re-writing it.
+		cmd := commandBuffer.Commands[cutCommand]
 		c, a := AddCommand(ctx, commandBufferId, s, cmd.recreateData)
 		x = append(x, a)
 		cleanup = append(cleanup, c)
+	case len(idx) > 1:
+		// idx names a subcommand inside cutCommand's vkCmdExecuteCommands;
+		// rebuild the secondary buffer at idx[1] the same way, keep every
+		// secondary before it unchanged, and drop everything after it.
+		execSub := commandBuffer.Commands[cutCommand].recreateData.(*RecreateCmdExecuteCommandsData)
+		cutSecondary := uint32(idx[1])
+		newBuffers := map[uint32]VkCommandBuffer{}
+		for _, k := range execSub.CommandBuffers.KeysSorted() {
+			switch {
+			case k < cutSecondary:
+				newBuffers[k] = execSub.CommandBuffers[k]
+			case k == cutSecondary:
+				if _, descendsFurther := subcommandBound(idx, 2); !descendsFurther {
+					// idx names the secondary itself, the same way
+					// len(idx) == 1 above names cutCommand itself; keep it
+					// whole rather than rebuilding it with nothing in it.
+					newBuffers[k] = execSub.CommandBuffers[k]
+					continue
+				}
+				secondary := GetState(s).CommandBuffers[execSub.CommandBuffers[k]]
+				rebuiltSecondary, secondaryCommands, secondaryCleanup := rebuildCommandBuffer(ctx, secondary, s, idx[2:], nil)
+				x = append(x, secondaryCommands...)
+				cleanup = append(cleanup, secondaryCleanup...)
+				newBuffers[k] = rebuiltSecondary
+			}
+		}
+		c, a := AddCommand(ctx, commandBufferId, s, RecreateCmdExecuteCommandsData{CommandBuffers: newBuffers})
+		x = append(x, a)
+		cleanup = append(cleanup, c)
 	}
 	for i := range additionalCommands {
 		c, a := AddCommand(ctx, commandBufferId, s, additionalCommands[i])
@@ -254,13 +335,109 @@ func rebuildCommandBuffer(ctx context.Context,
 	return VkCommandBuffer(commandBufferId), x, cleanup
 }
 
+// discardedSetEvents collects exactly the VkEvents a vkCmdSetEvent
+// discarded by a cut at idx would have signalled: every vkCmdSetEvent, at
+// any depth of nested vkCmdExecuteCommands, whose path idx addresses as
+// strictly after it. It walks the whole of commandBuffer via
+// walkCommandList -- the same traversal rebuildCommandBuffer and
+// WalkSubcommands share -- and classifies each command it reaches by
+// comparing its path against idx with gfxapi.SubcommandIndex.LessThan,
+// rather than re-deriving where the cut falls from idx[0]/idx[1] a third
+// time: that re-derivation is exactly what let a boundary-exclusion bug
+// slip into an earlier version of this function.
+func discardedSetEvents(s *State, commandBuffer *CommandBufferObject, idx gfxapi.SubcommandIndex) map[VkEvent]bool {
+	events := map[VkEvent]bool{}
+	walkCommandList(s, nil, nil, commandBuffer.Commands, func(path gfxapi.SubcommandIndex, o *CommandBufferCommand) Action {
+		if set, ok := o.recreateData.(*RecreateCmdSetEventData); ok && idx.LessThan(path) {
+			events[set.Event] = true
+		}
+		return Descend
+	})
+	return events
+}
+
+// releaseDanglingWaits strips out any VkSemaphore that submits waits on
+// but that the terminator already knows will never be signalled by the
+// trace on its own, because a cut (the one in progress or an earlier one)
+// discarded whoever would have signalled it. Each stripped wait is replaced with an immediate
+// compensating no-op submit that signals the semaphore directly, so this
+// submission does not block forever on a signal that would otherwise only
+// ever arrive, if at all, once Flush synthesizes it at the end of replay.
+func releaseDanglingWaits(ctx context.Context, t *VulkanTerminator, a atom.Atom, s *gfxapi.State,
+	l *memory.Layout, queue VkQueue, submits []VkSubmitInfo, submitCopy *VkQueueSubmit, out transform.Writer) {
+	if len(t.pendingSemaphores) == 0 {
+		return
+	}
+	var stale []VkSemaphore
+	for i := range submits {
+		waits := readSemaphores(ctx, a, s, l, submits[i].PWaitSemaphores, submits[i].WaitSemaphoreCount)
+		if len(waits) == 0 {
+			continue
+		}
+		stages := readStageMasks(ctx, a, s, l, submits[i].PWaitDstStageMask, submits[i].WaitSemaphoreCount)
+		kept := make([]VkSemaphore, 0, len(waits))
+		keptStages := make([]VkPipelineStageFlags, 0, len(waits))
+		for j, sem := range waits {
+			if t.pendingSemaphores[sem] {
+				stale = append(stale, sem)
+				delete(t.pendingSemaphores, sem)
+				continue
+			}
+			kept = append(kept, sem)
+			keptStages = append(keptStages, stages[j])
+		}
+		if len(kept) != len(waits) {
+			data := atom.Must(atom.AllocData(ctx, s, kept))
+			stageData := atom.Must(atom.AllocData(ctx, s, keptStages))
+			submits[i].WaitSemaphoreCount = uint32(len(kept))
+			submits[i].PWaitSemaphores = NewVkSemaphoreᶜᵖ(data.Ptr())
+			submits[i].PWaitDstStageMask = NewVkPipelineStageFlagsᶜᵖ(stageData.Ptr())
+			submitCopy.AddRead(data.Data()).AddRead(stageData.Data())
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	out.MutateAndWrite(ctx, atom.NoID, signalSemaphoresSubmit(ctx, s, queue, VkFence(0), stale))
+}
+
+// signalSemaphoresSubmit builds a vkQueueSubmit with no command buffers
+// that exists purely to signal the given semaphores (and, if non-zero,
+// fence). This is the compensating atom used whenever the terminator has
+// to make good on a signal that a cut submission can no longer produce.
+func signalSemaphoresSubmit(ctx context.Context, s *gfxapi.State, queue VkQueue, fence VkFence, semaphores []VkSemaphore) *VkQueueSubmit {
+	semaphoreData := atom.Must(atom.AllocData(ctx, s, semaphores))
+	info := VkSubmitInfo{
+		VkStructureType_VK_STRUCTURE_TYPE_SUBMIT_INFO,
+		NewVoidᶜᵖ(memory.Nullptr),
+		uint32(0),
+		NewVkSemaphoreᶜᵖ(memory.Nullptr),
+		NewVkPipelineStageFlagsᶜᵖ(memory.Nullptr),
+		uint32(0),
+		NewVkCommandBufferᶜᵖ(memory.Nullptr),
+		uint32(len(semaphores)),
+		NewVkSemaphoreᶜᵖ(semaphoreData.Ptr()),
+	}
+	infoData := atom.Must(atom.AllocData(ctx, s, info))
+	submit := NewVkQueueSubmit(queue, uint32(1), NewVkSubmitInfoᶜᵖ(infoData.Ptr()), fence, VkResult_VK_SUCCESS)
+	submit.AddRead(infoData.Data()).AddRead(semaphoreData.Data())
+	return submit
+}
+
 // cutCommandBuffer rebuilds the given VkQueueSubmit atom.
 // It will re-write the submission so that it ends at
 // idx. It writes any new atoms to transform.Writer.
 // It will make sure that if the replay were to stop at the given
 // index it would remain valid. This means closing any open
 // RenderPasses.
-func cutCommandBuffer(ctx context.Context, id atom.ID,
+//
+// Property #2: anything the discarded tail of this submission would have
+// signalled (a vkCmdSetEvent, or the signal semaphores of a VkSubmitInfo
+// dropped entirely) is recorded on t so that VulkanTerminator keeps
+// watching the trace until it is satisfied, synthesizing it in Flush if
+// the trace never gets there. The submission's fence stays on the
+// rewritten submission, so it is not recorded.
+func cutCommandBuffer(ctx context.Context, t *VulkanTerminator, id atom.ID,
 	a atom.Atom, idx gfxapi.SubcommandIndex, out transform.Writer) {
 	submit := a.(*VkQueueSubmit)
 	s := out.State()
@@ -272,12 +449,11 @@ func cutCommandBuffer(ctx context.Context, id atom.ID,
 	skipAll := len(idx) == 0
 
 	// Notes:
-	// - We should walk/finish all unfinished render passes
-	// idx[0] is the submission index
-	// idx[1] is the primary command-buffer index in the submission
-	// idx[2] is the command index in the primary command-buffer
-	// idx[3] is the secondary command buffer index inside a vkCmdExecuteCommands
-	// idx[4] is the secondary command inside the secondary command-buffer
+	// - We should walk/finish all unfinished render passes.
+	// - idx[0] is the submission index and idx[1] the primary command
+	//   buffer index within it; idx[2:] addresses the subcommand inside
+	//   that command buffer the same way rebuildCommandBuffer's own idx
+	//   parameter does, however deep it nests into vkCmdExecuteCommands.
 	submitCopy := NewVkQueueSubmit(submit.Queue, submit.SubmitCount, submit.PSubmits,
 		submit.Fence, submit.Result)
 	submitCopy.Extras().Add(a.Extras().All()...)
@@ -315,10 +491,12 @@ func cutCommandBuffer(ctx context.Context, id atom.ID,
 			lsp = 0
 		}
 	}
-	lrp, lsp = resolveCurrentRenderPass(ctx, s, submit, idx, lrp, lsp)
+	rps := resolveCurrentRenderPass(ctx, s, submit, idx, lrp, lsp)
+	lrp, lsp = rps.lrp, rps.subpass
 
 	extraCommands := make([]interface{}, 0)
 	if lrp != nil {
+		extraCommands = append(extraCommands, attachmentBarriers(c, lrp, rps.fb, rps.history)...)
 		numSubpasses := uint32(len(lrp.SubpassDescriptions))
 		for i := 0; uint32(i) < numSubpasses-lsp-1; i++ {
 			extraCommands = append(extraCommands, RecreateCmdNextSubpassData{})
@@ -331,6 +509,28 @@ func cutCommandBuffer(ctx context.Context, id atom.ID,
 	if !skipAll {
 		subIdx = idx[2:]
 	}
+
+	if !skipAll {
+		for e := range discardedSetEvents(c, cmdBuffer, subIdx) {
+			t.blockingEvents[e] = true
+		}
+		// newSubmits[lastSubmit] is retained and re-dispatched for real by
+		// submitCopy below, so whatever it signals still happens -- it is
+		// not orphaned. What submitCopy drops entirely is every VkSubmitInfo
+		// after lastSubmit; those are the ones whose signals this cut must
+		// start watching for.
+		for i := lastSubmit + 1; i < uint64(submit.SubmitCount); i++ {
+			discarded := submitInfo.Index(i, l).Read(ctx, a, s, nil)
+			for _, sem := range readSemaphores(ctx, a, s, l, discarded.PSignalSemaphores, discarded.SignalSemaphoreCount) {
+				t.pendingSemaphores[sem] = true
+			}
+		}
+		if len(t.blockingEvents) > 0 || len(t.pendingSemaphores) > 0 {
+			t.pendingQueue = submit.Queue
+		}
+		releaseDanglingWaits(ctx, t, a, s, l, submit.Queue, newSubmits, submitCopy, out)
+	}
+
 	b, newCommands, cleanup :=
 		rebuildCommandBuffer(ctx, cmdBuffer, s, subIdx, extraCommands)
 	newCommandBuffers[lastCommandBuffer] = b
@@ -378,14 +578,54 @@ func (t *VulkanTerminator) Transform(ctx context.Context, id atom.ID, a atom.Ato
 	// We have to cut somewhere
 	if doCut {
 		cutIndex.Decrement()
-		cutCommandBuffer(ctx, id, a, cutIndex, out)
+		cutCommandBuffer(ctx, t, id, a, cutIndex, out)
+		t.cut = true
 	} else {
 		out.MutateAndWrite(ctx, id, a)
+		if t.cut {
+			t.observeSync(ctx, a, out)
+		}
 	}
 
-	if id == t.lastRequest {
+	if t.cut {
+		if !t.pending() {
+			t.stopped = true
+		}
+	} else if id == t.lastRequest {
 		t.stopped = true
 	}
 }
 
-func (t *VulkanTerminator) Flush(ctx context.Context, out transform.Writer) {}
+// Flush is called once the trace has been fully consumed. If a mid-submit
+// cut left blockingEvents, pendingSemaphores or a pendingFence unresolved
+// -- because the trace ended before whatever would naturally have
+// signalled them -- synthesize the minimal atoms needed to satisfy them,
+// so that anything downstream waiting on this replay does not hang.
+func (t *VulkanTerminator) Flush(ctx context.Context, out transform.Writer) {
+	if !t.cut || !t.pending() {
+		return
+	}
+	s := out.State()
+	c := GetState(s)
+	for e := range t.blockingEvents {
+		ev, ok := c.Events[e]
+		if !ok {
+			// The event was destroyed before the trace ended; there is
+			// nothing left to signal it on behalf of.
+			continue
+		}
+		out.MutateAndWrite(ctx, atom.NoID, NewVkSetEvent(ev.Device, e, VkResult_VK_SUCCESS))
+	}
+	t.blockingEvents = map[VkEvent]bool{}
+
+	if len(t.pendingSemaphores) > 0 || t.pendingFence != VkFence(0) {
+		semaphores := make([]VkSemaphore, 0, len(t.pendingSemaphores))
+		for sem := range t.pendingSemaphores {
+			semaphores = append(semaphores, sem)
+		}
+		out.MutateAndWrite(ctx, atom.NoID, signalSemaphoresSubmit(ctx, s, t.pendingQueue, t.pendingFence, semaphores))
+		t.pendingSemaphores = map[VkSemaphore]bool{}
+		t.pendingFence = VkFence(0)
+	}
+	t.stopped = true
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vulkan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/gapid/core/assert"
+	"github.com/google/gapid/core/log"
+	"github.com/google/gapid/gapis/atom"
+	"github.com/google/gapid/gapis/gfxapi"
+	"github.com/google/gapid/gapis/memory"
+)
+
+// fakeWriter is just enough of a transform.Writer to drive observeSync/
+// Flush in isolation: it records whatever gets written and hands back a
+// nil state.
+type fakeWriter struct {
+	written []atom.Atom
+}
+
+func (f *fakeWriter) State() *gfxapi.State { return nil }
+
+func (f *fakeWriter) MutateAndWrite(ctx context.Context, id atom.ID, cmd atom.Atom) {
+	f.written = append(f.written, cmd)
+}
+
+func TestPending(t *testing.T) {
+	ctx := log.Testing(t)
+	term := &VulkanTerminator{}
+	assert.With(ctx).That(term.pending()).Equals(false)
+
+	term.blockingEvents = map[VkEvent]bool{VkEvent(1): true}
+	assert.With(ctx).That(term.pending()).Equals(true)
+
+	term.blockingEvents = map[VkEvent]bool{}
+	term.pendingSemaphores = map[VkSemaphore]bool{VkSemaphore(1): true}
+	assert.With(ctx).That(term.pending()).Equals(true)
+
+	term.pendingSemaphores = map[VkSemaphore]bool{}
+	term.pendingFence = VkFence(1)
+	assert.With(ctx).That(term.pending()).Equals(true)
+
+	term.pendingFence = VkFence(0)
+	assert.With(ctx).That(term.pending()).Equals(false)
+}
+
+func TestObserveSyncClearsBlockingEventOnSetEvent(t *testing.T) {
+	ctx := log.Testing(t)
+	ev := VkEvent(1)
+	term := &VulkanTerminator{
+		blockingEvents:    map[VkEvent]bool{ev: true},
+		pendingSemaphores: map[VkSemaphore]bool{},
+	}
+
+	term.observeSync(ctx, NewVkSetEvent(VkDevice(0), ev, VkResult_VK_SUCCESS), &fakeWriter{})
+
+	assert.With(ctx).That(term.blockingEvents[ev]).Equals(false)
+	assert.With(ctx).That(term.pending()).Equals(false)
+}
+
+func TestObserveSyncClearsBlockingEventOnCmdSetEvent(t *testing.T) {
+	ctx := log.Testing(t)
+	ev := VkEvent(1)
+	term := &VulkanTerminator{
+		blockingEvents:    map[VkEvent]bool{ev: true},
+		pendingSemaphores: map[VkSemaphore]bool{},
+	}
+
+	term.observeSync(ctx, NewVkCmdSetEvent(VkCommandBuffer(0), ev, VkPipelineStageFlags(0)), &fakeWriter{})
+
+	assert.With(ctx).That(term.blockingEvents[ev]).Equals(false)
+}
+
+func TestObserveSyncClearsPendingFenceOnMatchingSubmit(t *testing.T) {
+	ctx := log.Testing(t)
+	fence := VkFence(5)
+	term := &VulkanTerminator{
+		pendingFence:      fence,
+		pendingSemaphores: map[VkSemaphore]bool{},
+	}
+
+	// With pendingSemaphores empty, observeSync returns right after the
+	// fence check, before it calls State() or reads PSubmits out of
+	// memory, so this is safe to drive without a real *gfxapi.State.
+	submit := NewVkQueueSubmit(VkQueue(0), uint32(0), NewVkSubmitInfoᶜᵖ(memory.Nullptr), fence, VkResult_VK_SUCCESS)
+	term.observeSync(ctx, submit, &fakeWriter{})
+
+	assert.With(ctx).That(term.pendingFence).Equals(VkFence(0))
+}
+
+func TestObserveSyncIgnoresNonMatchingFence(t *testing.T) {
+	ctx := log.Testing(t)
+	term := &VulkanTerminator{
+		pendingFence:      VkFence(5),
+		pendingSemaphores: map[VkSemaphore]bool{},
+	}
+
+	submit := NewVkQueueSubmit(VkQueue(0), uint32(0), NewVkSubmitInfoᶜᵖ(memory.Nullptr), VkFence(6), VkResult_VK_SUCCESS)
+	term.observeSync(ctx, submit, &fakeWriter{})
+
+	assert.With(ctx).That(term.pendingFence).Equals(VkFence(5))
+}
+
+func TestDiscardedSetEventsKeepsSecondariesBeforeAndAtNestedCut(t *testing.T) {
+	ctx := log.Testing(t)
+
+	secondaryHandle := VkCommandBuffer(1)
+	secondary := &CommandBufferObject{
+		Commands: CommandBufferCommands{
+			{recreateData: &RecreateCmdSetEventData{Event: VkEvent(10)}},
+			{recreateData: &RecreateCmdSetEventData{Event: VkEvent(11)}},
+		},
+	}
+	s := &State{
+		CommandBuffers: map[VkCommandBuffer]*CommandBufferObject{secondaryHandle: secondary},
+	}
+	primary := &CommandBufferObject{
+		Commands: CommandBufferCommands{
+			{recreateData: &RecreateCmdSetEventData{Event: VkEvent(1)}},
+			{recreateData: &RecreateCmdExecuteCommandsData{
+				CommandBuffers: map[uint32]VkCommandBuffer{0: secondaryHandle},
+			}},
+			{recreateData: &RecreateCmdSetEventData{Event: VkEvent(2)}},
+		},
+	}
+
+	// Cut at {1, 0}: keep command 0, and secondary 0 of command 1's
+	// vkCmdExecuteCommands, in full -- nothing inside the secondary should
+	// be discarded, only command 2, which comes after the boundary
+	// vkCmdExecuteCommands entirely. A tail-boundary bug once made this
+	// wrongly report events 10 and 11 as discarded too.
+	events := discardedSetEvents(s, primary, gfxapi.SubcommandIndex{1, 0})
+
+	assert.With(ctx).That(events[VkEvent(1)]).Equals(false)
+	assert.With(ctx).That(events[VkEvent(10)]).Equals(false)
+	assert.With(ctx).That(events[VkEvent(11)]).Equals(false)
+	assert.With(ctx).That(events[VkEvent(2)]).Equals(true)
+}
+
+func TestDiscardedSetEventsDropsSecondariesAfterNestedCut(t *testing.T) {
+	ctx := log.Testing(t)
+
+	keptHandle := VkCommandBuffer(1)
+	droppedHandle := VkCommandBuffer(2)
+	kept := &CommandBufferObject{
+		Commands: CommandBufferCommands{{recreateData: &RecreateCmdSetEventData{Event: VkEvent(10)}}},
+	}
+	dropped := &CommandBufferObject{
+		Commands: CommandBufferCommands{{recreateData: &RecreateCmdSetEventData{Event: VkEvent(20)}}},
+	}
+	s := &State{
+		CommandBuffers: map[VkCommandBuffer]*CommandBufferObject{
+			keptHandle:    kept,
+			droppedHandle: dropped,
+		},
+	}
+	primary := &CommandBufferObject{
+		Commands: CommandBufferCommands{
+			{recreateData: &RecreateCmdExecuteCommandsData{
+				CommandBuffers: map[uint32]VkCommandBuffer{0: keptHandle, 1: droppedHandle},
+			}},
+		},
+	}
+
+	// Cut at {0, 0}: keep secondary 0 in full; secondary 1 is entirely
+	// after the cut and everything it sets is discarded.
+	events := discardedSetEvents(s, primary, gfxapi.SubcommandIndex{0, 0})
+
+	assert.With(ctx).That(events[VkEvent(10)]).Equals(false)
+	assert.With(ctx).That(events[VkEvent(20)]).Equals(true)
+}